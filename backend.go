@@ -0,0 +1,73 @@
+package smbus_go
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// Backend abstracts the low-level transport an SMBus talks to. The only
+// production implementation is linuxBackend, which issues real ioctls
+// against an open /dev/i2c-N file descriptor; the mockbus subpackage
+// provides an in-memory Backend for unit-testing code that depends on this
+// package without real I2C hardware.
+//
+// Ioctl commands come in two shapes: some (like I2CSlave or I2CPEC) take a
+// plain integer argument, while others (like I2CSMBus or I2CRDWR) take a
+// pointer to a C struct. These are split into two methods rather than one
+// taking an interface{} or unsafe.Pointer for both, because an implementation
+// that needs to dereference a struct argument (such as mockbus, which has no
+// real kernel on the other end to interpret it) cannot safely reconstruct a
+// Pointer from a uintptr that crossed a function-call boundary: Go's checkptr
+// instrumentation (enabled by "go test -race" and "go build -d=checkptr")
+// treats that as invalid pointer arithmetic and crashes at runtime, even when
+// the underlying value is a legitimate, live pointer.
+type Backend interface {
+	// Issues an ioctl whose argument is a raw integer value, e.g. I2CSlave,
+	// I2CSlaveForce, I2CPEC, I2CTenBitAddr, I2CRetries, or I2CTimeout.
+	IoctlInt(cmd uintptr, arg uintptr) error
+	// Issues an ioctl whose argument is a pointer to a C struct, e.g.
+	// I2CSMBus, I2CRDWR, or I2CFuncs. Callers are responsible for keeping
+	// the pointee referenced by arg alive until IoctlPointer returns.
+	IoctlPointer(cmd uintptr, arg unsafe.Pointer) error
+	// Releases any resources held by the backend.
+	Close() error
+}
+
+// The default Backend, talking to a real Linux /dev/i2c-N device via ioctl.
+type linuxBackend struct {
+	fd int
+}
+
+// Opens path (e.g. "/dev/i2c-0") and returns a Backend that issues ioctls
+// against it.
+func newLinuxBackend(path string) (*linuxBackend, error) {
+	fd, e := syscall.Open(path, syscall.O_RDWR, 0666)
+	if e != nil {
+		return nil, fmt.Errorf("Error opening %s: %w", path, e)
+	}
+	return &linuxBackend{fd: fd}, nil
+}
+
+func (l *linuxBackend) IoctlInt(cmd uintptr, arg uintptr) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(l.fd), cmd, arg)
+	if errno != 0 {
+		return fmt.Errorf("Error making ioctl: %w", errno)
+	}
+	return nil
+}
+
+func (l *linuxBackend) IoctlPointer(cmd uintptr, arg unsafe.Pointer) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(l.fd), cmd,
+		uintptr(arg))
+	if errno != 0 {
+		return fmt.Errorf("Error making ioctl: %w", errno)
+	}
+	return nil
+}
+
+func (l *linuxBackend) Close() error {
+	e := syscall.Close(l.fd)
+	l.fd = -1
+	return e
+}
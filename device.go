@@ -0,0 +1,96 @@
+package smbus_go
+
+// A Device binds a single slave address to an underlying SMBus, so that
+// callers don't need to repeat the address on every call. Several Device
+// values may share one *SMBus (e.g. a multiplexed set of sensors on a single
+// physical bus); the SMBus itself serializes access via its internal mutex,
+// so it's safe to use Device handles sharing a bus from multiple goroutines.
+type Device struct {
+	bus     *SMBus
+	address uintptr
+}
+
+// Returns a Device bound to the given slave address on bus. The bus may be
+// shared with other Device instances.
+func NewDevice(bus *SMBus, address uintptr) *Device {
+	return &Device{
+		bus:     bus,
+		address: address,
+	}
+}
+
+// Returns the SMBus underlying this Device.
+func (d *Device) Bus() *SMBus {
+	return d.bus
+}
+
+// Returns the slave address this Device is bound to.
+func (d *Device) Address() uintptr {
+	return d.address
+}
+
+// See SMBus.WriteQuick.
+func (d *Device) WriteQuick() error {
+	return d.bus.WriteQuick(d.address)
+}
+
+// See SMBus.ReadByte.
+func (d *Device) ReadByte() (uint8, error) {
+	return d.bus.ReadByte(d.address)
+}
+
+// See SMBus.WriteByte.
+func (d *Device) WriteByte(value uint8) error {
+	return d.bus.WriteByte(d.address, value)
+}
+
+// See SMBus.ReadByteData.
+func (d *Device) ReadRegister(register uint8) (uint8, error) {
+	return d.bus.ReadByteData(d.address, register)
+}
+
+// See SMBus.WriteByteData.
+func (d *Device) WriteRegister(register, value uint8) error {
+	return d.bus.WriteByteData(d.address, register, value)
+}
+
+// See SMBus.ReadWordData.
+func (d *Device) ReadWordRegister(register uint8) (uint16, error) {
+	return d.bus.ReadWordData(d.address, register)
+}
+
+// See SMBus.WriteWordData.
+func (d *Device) WriteWordRegister(register uint8, value uint16) error {
+	return d.bus.WriteWordData(d.address, register, value)
+}
+
+// See SMBus.ProcessCall.
+func (d *Device) ProcessCall(register uint8, value uint16) (uint16, error) {
+	return d.bus.ProcessCall(d.address, register, value)
+}
+
+// See SMBus.ReadBlockData.
+func (d *Device) ReadBlock(register uint8) ([]byte, error) {
+	return d.bus.ReadBlockData(d.address, register)
+}
+
+// See SMBus.WriteBlockData.
+func (d *Device) WriteBlock(register uint8, values []byte) error {
+	return d.bus.WriteBlockData(d.address, register, values)
+}
+
+// See SMBus.BlockProcessCall.
+func (d *Device) BlockProcessCall(register uint8, values []byte) ([]byte,
+	error) {
+	return d.bus.BlockProcessCall(d.address, register, values)
+}
+
+// See SMBus.ReadI2CBlockData.
+func (d *Device) ReadI2CBlock(register, length uint8) ([]byte, error) {
+	return d.bus.ReadI2CBlockData(d.address, register, length)
+}
+
+// See SMBus.WriteI2CBlockData.
+func (d *Device) WriteI2CBlock(register uint8, values []byte) error {
+	return d.bus.WriteI2CBlockData(d.address, register, values)
+}
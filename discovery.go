@@ -0,0 +1,84 @@
+package smbus_go
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Describes one I2C adapter found by ListBuses.
+type BusInfo struct {
+	// The N in /dev/i2c-N.
+	BusID int
+	// The full device path, e.g. "/dev/i2c-1".
+	Path string
+	// The adapter's name, read from
+	// /sys/class/i2c-dev/i2c-N/name, e.g. "bcm2835 I2C adapter". Empty if
+	// the name couldn't be read.
+	Name string
+}
+
+// Enumerates the I2C adapters available on this system by globbing
+// /dev/i2c-*, the same set i2c-tools' i2cdetect -l lists.
+func ListBuses() ([]BusInfo, error) {
+	paths, e := filepath.Glob("/dev/i2c-*")
+	if e != nil {
+		return nil, fmt.Errorf("Error listing /dev/i2c-* devices: %w", e)
+	}
+	toReturn := make([]BusInfo, 0, len(paths))
+	for _, path := range paths {
+		busID, e := strconv.Atoi(strings.TrimPrefix(filepath.Base(path),
+			"i2c-"))
+		if e != nil {
+			continue
+		}
+		nameBytes, _ := os.ReadFile(fmt.Sprintf(
+			"/sys/class/i2c-dev/i2c-%d/name", busID))
+		toReturn = append(toReturn, BusInfo{
+			BusID: busID,
+			Path:  path,
+			Name:  strings.TrimSpace(string(nameBytes)),
+		})
+	}
+	return toReturn, nil
+}
+
+// Returns true if it's unsafe to issue anything but a zero-length quick
+// write to the given address, matching i2cdetect's default behavior: the
+// 0x30-0x37 and 0x50-0x5f ranges are reserved for devices (e.g. some EEPROM
+// and RTC chips) that can be disrupted by a read.
+func writeOnlySafeAddress(address uint16) bool {
+	return (address >= 0x30 && address <= 0x37) ||
+		(address >= 0x50 && address <= 0x5f)
+}
+
+// Probes every address in [startAddr, endAddr] and returns those that
+// acknowledge a transaction, using the safest primitive available for each
+// address the same way i2cdetect does: a zero-length quick write for
+// addresses in the write-only-safe ranges, and a ReadByte everywhere else.
+// Addresses below 0x03 or above 0x77 are skipped, since those are reserved.
+func (b *SMBus) Scan(startAddr, endAddr uint16) ([]uint16, error) {
+	if endAddr < startAddr {
+		return nil, fmt.Errorf("endAddr (0x%02x) is less than startAddr "+
+			"(0x%02x)", endAddr, startAddr)
+	}
+	found := make([]uint16, 0)
+	for addrInt := int(startAddr); addrInt <= int(endAddr); addrInt++ {
+		addr := uint16(addrInt)
+		if addr < 0x03 || addr > 0x77 {
+			continue
+		}
+		var e error
+		if writeOnlySafeAddress(addr) {
+			e = b.WriteQuick(uintptr(addr))
+		} else {
+			_, e = b.ReadByte(uintptr(addr))
+		}
+		if e == nil {
+			found = append(found, addr)
+		}
+	}
+	return found, nil
+}
@@ -14,7 +14,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"github.com/yalue/native_endian"
-	"syscall"
+	"sync"
 	"unsafe"
 )
 
@@ -38,6 +38,13 @@ const (
 	I2CSMBus = 0x0720
 	// != 0 to use PEC with SMBus
 	I2CPEC = 0x0708
+	// Number of times a device address should be polled when not
+	// acknowledged.
+	I2CRetries = 0x0701
+	// Set a timeout, in units of 10ms, for transactions on this bus.
+	I2CTimeout = 0x0702
+	// != 0 to use 10-bit slave addresses for subsequent transactions.
+	I2CTenBitAddr = 0x0704
 
 	// SMBus transfer read or write markers from uapi/linux/i2c.h
 	I2CSMBusWrite = 0
@@ -92,6 +99,20 @@ const (
 
 	// i2c_msg flags from uapi/linux/i2c.h
 	I2CMRD = 0x0001
+	// This message uses a 10-bit slave address.
+	I2CMTenBit = 0x0010
+	// Don't issue a STOP or repeated START before this message; continue the
+	// in-progress transaction.
+	I2CMNoStart = 0x4000
+	// Message length is ignored; the slave's first returned byte gives the
+	// actual transfer length instead. Used to emulate SMBus block reads over
+	// I2C_RDWR on adapters that don't support them natively.
+	I2CMRecvLen = 0x0400
+
+	// The kernel rejects a single I2C_RDWR ioctl carrying more than this
+	// many messages; Transaction.Execute splits larger transactions into
+	// multiple ioctls at this boundary.
+	I2CRdwrIoctlMaxMsgs = 42
 )
 
 // These represent a bitfield indicating the capabilities of a bus.
@@ -214,18 +235,12 @@ type I2CSMBusIoctlData struct {
 	Data unsafe.Pointer
 }
 
-// Provides a ioctl wrapper that works with the syscall library.
-func ioctl(fd int, cmd uintptr, arg uintptr) error {
-	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), cmd, arg)
-	if errno != 0 {
-		return fmt.Errorf("Error making ioctl: %w", errno)
-	}
-	return nil
-}
-
 // Tracks state for a single open bus.
 type SMBus struct {
-	fd int
+	// Issues the actual ioctls for this bus. Normally a linuxBackend, but
+	// swappable (e.g. for the mockbus subpackage) so that code built on top
+	// of SMBus can be unit-tested without real I2C hardware.
+	backend Backend
 	// A bitfield indicating what functions are supported by the I2C device.
 	Funcs   FunctionFlags
 	address uintptr
@@ -233,17 +248,28 @@ type SMBus struct {
 	// The value of Force for the previous call to setAddress.
 	prevForce  bool
 	pecEnabled bool
+	tenBit     bool
+	// The retry count and timeout (in units of 10ms) last set via Do's
+	// TransactionOptions, so Do can restore them afterward. Zero means the
+	// adapter's power-on default, which this library never explicitly
+	// changes outside of Do.
+	retries int
+	timeout int
 	// Used for converting from Go's byte-slice representation of unions to
 	// multi-byte types.
 	nativeByteOrder binary.ByteOrder
+	// Guards setAddress plus the ioctl that follows it, since setAddress
+	// caches the currently-selected address on the SMBus struct itself.
+	// Without this, two goroutines sharing one SMBus (e.g. via separate
+	// Device handles) could interleave their setAddress calls and end up
+	// issuing a transaction against the wrong slave.
+	mu sync.Mutex
 }
 
 // Should be called when the SMBus connection is no longer needed. Closes the
 // underlying file descriptor.
 func (b *SMBus) Close() error {
-	e := syscall.Close(b.fd)
-	b.fd = -1
-	return e
+	return b.backend.Close()
 }
 
 // Opens the given I2C bus. Requires the I2C bus number.
@@ -253,18 +279,25 @@ func NewSMBus(busID int) (*SMBus, error) {
 
 // Like NewSMBus, but takes a path to an smbus device, i.e., "/dev/i2c-0".
 func NewSMBusWithPath(path string) (*SMBus, error) {
-	fd, e := syscall.Open(path, syscall.O_RDWR, 0666)
+	backend, e := newLinuxBackend(path)
 	if e != nil {
-		return nil, fmt.Errorf("Error opening %s: %w", path, e)
+		return nil, e
 	}
+	return NewSMBusWithBackend(backend)
+}
+
+// Like NewSMBus, but takes an already-constructed Backend rather than
+// opening a real Linux I2C device. Used by tests and by code that wants to
+// supply a mock Backend, such as the mockbus subpackage.
+func NewSMBusWithBackend(backend Backend) (*SMBus, error) {
 	funcs := uint32(0)
-	e = ioctl(fd, I2CFuncs, uintptr(unsafe.Pointer(&funcs)))
+	e := backend.IoctlPointer(I2CFuncs, unsafe.Pointer(&funcs))
 	if e != nil {
-		syscall.Close(fd)
-		return nil, fmt.Errorf("Error getting funcs for %s: %w", path, e)
+		backend.Close()
+		return nil, fmt.Errorf("Error getting funcs: %w", e)
 	}
 	return &SMBus{
-		fd:              fd,
+		backend:         backend,
 		Funcs:           FunctionFlags(funcs),
 		nativeByteOrder: native_endian.NativeEndian(),
 	}, nil
@@ -278,6 +311,15 @@ func (b *SMBus) PECEnabled() bool {
 // Enable or disable PEC (packet error checking). Returns an error if the
 // feature is not available, or if the ioctl fails for some reason.
 func (b *SMBus) EnablePEC(enable bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.enablePEC(enable)
+}
+
+// The guts of EnablePEC, factored out so that code which already holds b.mu
+// (such as Do in transaction_options.go) can toggle PEC without deadlocking
+// on a non-reentrant mutex.
+func (b *SMBus) enablePEC(enable bool) error {
 	if !b.Funcs.BitsSet(SMBusPECFlag) {
 		return fmt.Errorf("PEC is not a supported feature on this bus")
 	}
@@ -285,7 +327,7 @@ func (b *SMBus) EnablePEC(enable bool) error {
 	if enable {
 		arg = 1
 	}
-	e := ioctl(b.fd, I2CPEC, arg)
+	e := b.backend.IoctlInt(I2CPEC, arg)
 	if e != nil {
 		return fmt.Errorf("Error issuing I2C_PEC ioctl: %w", e)
 	}
@@ -293,6 +335,54 @@ func (b *SMBus) EnablePEC(enable bool) error {
 	return nil
 }
 
+// Returns true if 10-bit addressing is currently enabled for this bus.
+func (b *SMBus) TenBitEnabled() bool {
+	return b.tenBit
+}
+
+// Returns the retry count Do currently has applied: opts.Retries while a Do
+// callback runs, and the restored earlier value (zero if none) after Do
+// returns.
+func (b *SMBus) Retries() int {
+	return b.retries
+}
+
+// Returns the timeout (in units of 10ms) Do currently has applied:
+// opts.Timeout while a Do callback runs, and the restored earlier value (zero
+// if none) after Do returns.
+func (b *SMBus) Timeout() int {
+	return b.timeout
+}
+
+// Enable or disable 10-bit slave addressing for subsequent transactions.
+// Returns an error if the underlying adapter doesn't support 10-bit
+// addresses, or if the ioctl fails for some reason.
+func (b *SMBus) SetTenBit(enable bool) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.setTenBit(enable)
+}
+
+// The guts of SetTenBit, factored out so that code which already holds b.mu
+// (such as Do in transaction_options.go) can toggle 10-bit addressing
+// without deadlocking on a non-reentrant mutex.
+func (b *SMBus) setTenBit(enable bool) error {
+	if !b.Funcs.BitsSet(Addr10BitFlag) {
+		return fmt.Errorf("10-bit addressing is not a supported feature " +
+			"on this bus")
+	}
+	arg := uintptr(0)
+	if enable {
+		arg = 1
+	}
+	e := b.backend.IoctlInt(I2CTenBitAddr, arg)
+	if e != nil {
+		return fmt.Errorf("Error issuing I2C_TENBIT_ADDR ioctl: %w", e)
+	}
+	b.tenBit = enable
+	return nil
+}
+
 // Set the I2C slave address to use for subsequent calls. The overrideForce
 // argument is used because the force argument is optional in _set_address in
 // the python library.
@@ -300,9 +390,9 @@ func (b *SMBus) setAddress(address uintptr) error {
 	var e error
 	if (b.address != address) || (b.prevForce != b.Force) {
 		if b.Force {
-			e = ioctl(b.fd, I2CSlaveForce, address)
+			e = b.backend.IoctlInt(I2CSlaveForce, address)
 		} else {
-			e = ioctl(b.fd, I2CSlave, address)
+			e = b.backend.IoctlInt(I2CSlave, address)
 		}
 		if e != nil {
 			return fmt.Errorf("Error running slave address ioctl: %w", e)
@@ -315,6 +405,15 @@ func (b *SMBus) setAddress(address uintptr) error {
 
 // Perform a quick transaction.
 func (b *SMBus) WriteQuick(address uintptr) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.writeQuick(address)
+}
+
+// The guts of WriteQuick, factored out so that code which already holds
+// b.mu (such as Do in transaction_options.go) can issue a transaction
+// without deadlocking on a non-reentrant mutex.
+func (b *SMBus) writeQuick(address uintptr) error {
 	e := b.setAddress(address)
 	if e != nil {
 		return e
@@ -326,7 +425,7 @@ func (b *SMBus) WriteQuick(address uintptr) error {
 		size:       I2CSMBusQuick,
 		data:       &data,
 	}
-	e = ioctl(b.fd, I2CSMBus, uintptr(unsafe.Pointer(&msg)))
+	e = b.backend.IoctlPointer(I2CSMBus, unsafe.Pointer(&msg))
 	if e != nil {
 		return fmt.Errorf("Error issuing quick transaction ioctl: %w", e)
 	}
@@ -335,6 +434,15 @@ func (b *SMBus) WriteQuick(address uintptr) error {
 
 // Read a single byte from a device.
 func (b *SMBus) ReadByte(address uintptr) (uint8, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.readByte(address)
+}
+
+// The guts of ReadByte, factored out so that code which already holds b.mu
+// (such as Do in transaction_options.go) can issue a transaction without
+// deadlocking on a non-reentrant mutex.
+func (b *SMBus) readByte(address uintptr) (uint8, error) {
 	e := b.setAddress(address)
 	if e != nil {
 		return 0, e
@@ -346,7 +454,7 @@ func (b *SMBus) ReadByte(address uintptr) (uint8, error) {
 		size:       I2CSMBusByte,
 		data:       &data,
 	}
-	e = ioctl(b.fd, I2CSMBus, uintptr(unsafe.Pointer(&msg)))
+	e = b.backend.IoctlPointer(I2CSMBus, unsafe.Pointer(&msg))
 	if e != nil {
 		return 0, fmt.Errorf("Error issuing read byte ioctl: %w", e)
 	}
@@ -356,6 +464,15 @@ func (b *SMBus) ReadByte(address uintptr) (uint8, error) {
 
 // Write a single byte to the device.
 func (b *SMBus) WriteByte(address uintptr, value uint8) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.writeByte(address, value)
+}
+
+// The guts of WriteByte, factored out so that code which already holds b.mu
+// (such as Do in transaction_options.go) can issue a transaction without
+// deadlocking on a non-reentrant mutex.
+func (b *SMBus) writeByte(address uintptr, value uint8) error {
 	e := b.setAddress(address)
 	if e != nil {
 		return e
@@ -367,7 +484,7 @@ func (b *SMBus) WriteByte(address uintptr, value uint8) error {
 		size:       I2CSMBusByte,
 		data:       &data,
 	}
-	e = ioctl(b.fd, I2CSMBus, uintptr(unsafe.Pointer(&msg)))
+	e = b.backend.IoctlPointer(I2CSMBus, unsafe.Pointer(&msg))
 	if e != nil {
 		return fmt.Errorf("Error issuing write byte ioctl: %w", e)
 	}
@@ -376,6 +493,15 @@ func (b *SMBus) WriteByte(address uintptr, value uint8) error {
 
 // Reads a byte from a register.
 func (b *SMBus) ReadByteData(address uintptr, register uint8) (uint8, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.readByteData(address, register)
+}
+
+// The guts of ReadByteData, factored out so that code which already holds
+// b.mu (such as Do in transaction_options.go) can issue a transaction
+// without deadlocking on a non-reentrant mutex.
+func (b *SMBus) readByteData(address uintptr, register uint8) (uint8, error) {
 	e := b.setAddress(address)
 	if e != nil {
 		return 0, e
@@ -387,7 +513,7 @@ func (b *SMBus) ReadByteData(address uintptr, register uint8) (uint8, error) {
 		size:       I2CSMBusByteData,
 		data:       &data,
 	}
-	e = ioctl(b.fd, I2CSMBus, uintptr(unsafe.Pointer(&msg)))
+	e = b.backend.IoctlPointer(I2CSMBus, unsafe.Pointer(&msg))
 	if e != nil {
 		return 0, fmt.Errorf("Error issuing read byte data ioctl: %w", e)
 	}
@@ -395,6 +521,15 @@ func (b *SMBus) ReadByteData(address uintptr, register uint8) (uint8, error) {
 }
 
 func (b *SMBus) WriteByteData(address uintptr, register, value uint8) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.writeByteData(address, register, value)
+}
+
+// The guts of WriteByteData, factored out so that code which already holds
+// b.mu (such as Do in transaction_options.go) can issue a transaction
+// without deadlocking on a non-reentrant mutex.
+func (b *SMBus) writeByteData(address uintptr, register, value uint8) error {
 	e := b.setAddress(address)
 	if e != nil {
 		return e
@@ -407,7 +542,7 @@ func (b *SMBus) WriteByteData(address uintptr, register, value uint8) error {
 		size:       I2CSMBusByteData,
 		data:       &data,
 	}
-	e = ioctl(b.fd, I2CSMBus, uintptr(unsafe.Pointer(&msg)))
+	e = b.backend.IoctlPointer(I2CSMBus, unsafe.Pointer(&msg))
 	if e != nil {
 		return fmt.Errorf("Error issuing write byte data ioctl: %w", e)
 	}
@@ -416,6 +551,16 @@ func (b *SMBus) WriteByteData(address uintptr, register, value uint8) error {
 
 // Reads and returns a 2-byte word from a register.
 func (b *SMBus) ReadWordData(address uintptr, register uint8) (uint16, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.readWordData(address, register)
+}
+
+// The guts of ReadWordData, factored out so that code which already holds
+// b.mu (such as Do in transaction_options.go) can issue a transaction
+// without deadlocking on a non-reentrant mutex.
+func (b *SMBus) readWordData(address uintptr, register uint8) (uint16,
+	error) {
 	e := b.setAddress(address)
 	if e != nil {
 		return 0, e
@@ -427,7 +572,7 @@ func (b *SMBus) ReadWordData(address uintptr, register uint8) (uint16, error) {
 		size:       I2CSMBusWordData,
 		data:       &data,
 	}
-	e = ioctl(b.fd, I2CSMBus, uintptr(unsafe.Pointer(&msg)))
+	e = b.backend.IoctlPointer(I2CSMBus, unsafe.Pointer(&msg))
 	if e != nil {
 		return 0, fmt.Errorf("Error issuing read word data ioctl: %w", e)
 	}
@@ -437,6 +582,16 @@ func (b *SMBus) ReadWordData(address uintptr, register uint8) (uint16, error) {
 
 // Writes a 2-byte word to a register.
 func (b *SMBus) WriteWordData(address uintptr, register uint8,
+	value uint16) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.writeWordData(address, register, value)
+}
+
+// The guts of WriteWordData, factored out so that code which already holds
+// b.mu (such as Do in transaction_options.go) can issue a transaction
+// without deadlocking on a non-reentrant mutex.
+func (b *SMBus) writeWordData(address uintptr, register uint8,
 	value uint16) error {
 	e := b.setAddress(address)
 	if e != nil {
@@ -450,7 +605,7 @@ func (b *SMBus) WriteWordData(address uintptr, register uint8,
 		size:       I2CSMBusWordData,
 		data:       &data,
 	}
-	e = ioctl(b.fd, I2CSMBus, uintptr(unsafe.Pointer(&msg)))
+	e = b.backend.IoctlPointer(I2CSMBus, unsafe.Pointer(&msg))
 	if e != nil {
 		return fmt.Errorf("Error issuing write word data ioctl: %w", e)
 	}
@@ -460,6 +615,16 @@ func (b *SMBus) WriteWordData(address uintptr, register uint8,
 // Executes a SMBus process call, sending a 2-byte value and receiving a
 // 2-byte response.
 func (b *SMBus) ProcessCall(address uintptr, register uint8,
+	value uint16) (uint16, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.processCall(address, register, value)
+}
+
+// The guts of ProcessCall, factored out so that code which already holds
+// b.mu (such as Do in transaction_options.go) can issue a transaction
+// without deadlocking on a non-reentrant mutex.
+func (b *SMBus) processCall(address uintptr, register uint8,
 	value uint16) (uint16, error) {
 	e := b.setAddress(address)
 	if e != nil {
@@ -473,7 +638,7 @@ func (b *SMBus) ProcessCall(address uintptr, register uint8,
 		size:       I2CSMBusProcCall,
 		data:       &data,
 	}
-	e = ioctl(b.fd, I2CSMBus, uintptr(unsafe.Pointer(&msg)))
+	e = b.backend.IoctlPointer(I2CSMBus, unsafe.Pointer(&msg))
 	if e != nil {
 		return 0, fmt.Errorf("Error issuing proc call ioctl: %w", e)
 	}
@@ -484,6 +649,19 @@ func (b *SMBus) ProcessCall(address uintptr, register uint8,
 // Reads and returns a block of up to 32 bytes from the given register.
 func (b *SMBus) ReadBlockData(address uintptr, register uint8) ([]byte,
 	error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.readBlockData(address, register)
+}
+
+// The guts of ReadBlockData, factored out so that code which already holds
+// b.mu (such as Do in transaction_options.go) can issue a transaction
+// without deadlocking on a non-reentrant mutex.
+func (b *SMBus) readBlockData(address uintptr, register uint8) ([]byte,
+	error) {
+	if !b.Funcs.BitsSet(SMBusReadBlockDataFlag) {
+		return b.emulatedReadBlockData(address, register)
+	}
 	e := b.setAddress(address)
 	if e != nil {
 		return nil, e
@@ -495,7 +673,7 @@ func (b *SMBus) ReadBlockData(address uintptr, register uint8) ([]byte,
 		size:       I2CSMBusBlockData,
 		data:       &data,
 	}
-	e = ioctl(b.fd, I2CSMBus, uintptr(unsafe.Pointer(&msg)))
+	e = b.backend.IoctlPointer(I2CSMBus, unsafe.Pointer(&msg))
 	if e != nil {
 		return nil, fmt.Errorf("Error issuing read block data ioctl: %w", e)
 	}
@@ -510,15 +688,28 @@ func (b *SMBus) ReadBlockData(address uintptr, register uint8) ([]byte,
 // Writes a block of up to 32 bytes to the given register.
 func (b *SMBus) WriteBlockData(address uintptr, register uint8,
 	values []byte) error {
-	e := b.setAddress(address)
-	if e != nil {
-		return e
-	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.writeBlockData(address, register, values)
+}
+
+// The guts of WriteBlockData, factored out so that code which already holds
+// b.mu (such as Do in transaction_options.go) can issue a transaction
+// without deadlocking on a non-reentrant mutex.
+func (b *SMBus) writeBlockData(address uintptr, register uint8,
+	values []byte) error {
 	length := len(values)
 	if length > I2CSMBusBlockMax {
 		return fmt.Errorf("Block-data write of %d bytes exceeds limit of %d",
 			length, I2CSMBusBlockMax)
 	}
+	if !b.Funcs.BitsSet(SMBusWriteBlockDataFlag) {
+		return b.emulatedWriteBlockData(address, register, values)
+	}
+	e := b.setAddress(address)
+	if e != nil {
+		return e
+	}
 	var data C.union_i2c_smbus_data
 	data[0] = uint8(length)
 	copy(data[1:length+1], values)
@@ -528,7 +719,7 @@ func (b *SMBus) WriteBlockData(address uintptr, register uint8,
 		size:       I2CSMBusBlockData,
 		data:       &data,
 	}
-	e = ioctl(b.fd, I2CSMBus, uintptr(unsafe.Pointer(&msg)))
+	e = b.backend.IoctlPointer(I2CSMBus, unsafe.Pointer(&msg))
 	if e != nil {
 		return fmt.Errorf("Error issuing write block data ioctl: %w", e)
 	}
@@ -539,15 +730,28 @@ func (b *SMBus) WriteBlockData(address uintptr, register uint8,
 // a variable-length response.
 func (b *SMBus) BlockProcessCall(address uintptr, register uint8,
 	values []byte) ([]byte, error) {
-	e := b.setAddress(address)
-	if e != nil {
-		return nil, e
-	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.blockProcessCall(address, register, values)
+}
+
+// The guts of BlockProcessCall, factored out so that code which already
+// holds b.mu (such as Do in transaction_options.go) can issue a transaction
+// without deadlocking on a non-reentrant mutex.
+func (b *SMBus) blockProcessCall(address uintptr, register uint8,
+	values []byte) ([]byte, error) {
 	length := len(values)
 	if length > I2CSMBusBlockMax {
 		return nil, fmt.Errorf("Block-procedure call with %d input bytes "+
 			"exceeds limit of %d bytes", length, I2CSMBusBlockMax)
 	}
+	if !b.Funcs.BitsSet(SMBusBlockProcCallFlag) {
+		return b.emulatedBlockProcessCall(address, register, values)
+	}
+	e := b.setAddress(address)
+	if e != nil {
+		return nil, e
+	}
 	var data C.union_i2c_smbus_data
 	data[0] = uint8(length)
 	copy(data[1:length+1], values)
@@ -557,7 +761,7 @@ func (b *SMBus) BlockProcessCall(address uintptr, register uint8,
 		size:       I2CSMBusBlockProcCall,
 		data:       &data,
 	}
-	e = ioctl(b.fd, I2CSMBus, uintptr(unsafe.Pointer(&msg)))
+	e = b.backend.IoctlPointer(I2CSMBus, unsafe.Pointer(&msg))
 	if e != nil {
 		return nil, fmt.Errorf("Error issuing block proc call ioctl: %w", e)
 	}
@@ -571,6 +775,16 @@ func (b *SMBus) BlockProcessCall(address uintptr, register uint8,
 
 // Reads a block of data with the specified length from the specified register.
 func (b *SMBus) ReadI2CBlockData(address uintptr, register,
+	length uint8) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.readI2CBlockData(address, register, length)
+}
+
+// The guts of ReadI2CBlockData, factored out so that code which already
+// holds b.mu (such as Do in transaction_options.go) can issue a transaction
+// without deadlocking on a non-reentrant mutex.
+func (b *SMBus) readI2CBlockData(address uintptr, register,
 	length uint8) ([]byte, error) {
 	if length > I2CSMBusBlockMax {
 		return nil, fmt.Errorf("Requested length of %d bytes exceeds the "+
@@ -588,7 +802,7 @@ func (b *SMBus) ReadI2CBlockData(address uintptr, register,
 		size:       I2CSMBusI2CBlockData,
 		data:       &data,
 	}
-	e = ioctl(b.fd, I2CSMBus, uintptr(unsafe.Pointer(&msg)))
+	e = b.backend.IoctlPointer(I2CSMBus, unsafe.Pointer(&msg))
 	if e != nil {
 		return nil, fmt.Errorf("Error issuing i2c block data read ioctl: %w",
 			e)
@@ -598,6 +812,16 @@ func (b *SMBus) ReadI2CBlockData(address uintptr, register,
 
 // Writes a block of data to the specified register.
 func (b *SMBus) WriteI2CBlockData(address uintptr, register uint8,
+	values []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.writeI2CBlockData(address, register, values)
+}
+
+// The guts of WriteI2CBlockData, factored out so that code which already
+// holds b.mu (such as Do in transaction_options.go) can issue a transaction
+// without deadlocking on a non-reentrant mutex.
+func (b *SMBus) writeI2CBlockData(address uintptr, register uint8,
 	values []byte) error {
 	length := len(values)
 	if length > I2CSMBusBlockMax {
@@ -617,7 +841,7 @@ func (b *SMBus) WriteI2CBlockData(address uintptr, register uint8,
 		size:       I2CSMBusI2CBlockData,
 		data:       &data,
 	}
-	e = ioctl(b.fd, I2CSMBus, uintptr(unsafe.Pointer(&msg)))
+	e = b.backend.IoctlPointer(I2CSMBus, unsafe.Pointer(&msg))
 	if e != nil {
 		return fmt.Errorf("Error issuing i2c block data write ioctl: %w", e)
 	}
@@ -638,6 +862,15 @@ type I2CMessage struct {
 // Issues a list of messages. May be highly unsafe if any message doesn't have
 // a sufficient buffer. Use with caution.
 func (b *SMBus) I2CRdWr(messages []I2CMessage) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.i2cRdWr(messages)
+}
+
+// The guts of I2CRdWr, factored out so that code which already holds b.mu
+// (such as the block-data emulation in emulation.go) can issue a combined
+// transaction without deadlocking on a non-reentrant mutex.
+func (b *SMBus) i2cRdWr(messages []I2CMessage) error {
 	if len(messages) == 0 {
 		return fmt.Errorf("Got no messages")
 	}
@@ -657,7 +890,7 @@ func (b *SMBus) I2CRdWr(messages []I2CMessage) error {
 		msgs:  &(internalMessages[0]),
 		nmsgs: C.__u32(len(messages)),
 	}
-	e := ioctl(b.fd, I2CRDWR, uintptr(unsafe.Pointer(&ioctlData)))
+	e := b.backend.IoctlPointer(I2CRDWR, unsafe.Pointer(&ioctlData))
 	if e != nil {
 		return fmt.Errorf("Error issuing I2C_RDWR ioctl: %w", e)
 	}
@@ -0,0 +1,267 @@
+package smbus_go_test
+
+// These tests exercise smbus_go on top of mockbus, so they don't require
+// real I2C hardware the way smbus_test.go's TestOpenBus does. They live in
+// an external test package (rather than alongside smbus_test.go) because
+// mockbus imports smbus_go, and smbus_go can't import mockbus back without
+// creating an import cycle.
+
+import (
+	"fmt"
+	"testing"
+
+	smbus_go "github.com/yalue/smbus2_go"
+	"github.com/yalue/smbus2_go/mockbus"
+)
+
+// Funcs bitfield for a bus that natively supports every SMBus transfer type,
+// so block operations go through MockBus's doSMBusIoctl path rather than the
+// I2C_RDWR emulation in emulation.go.
+const fullFuncs = smbus_go.I2CFlag | smbus_go.SMBusPECFlag |
+	smbus_go.Addr10BitFlag | smbus_go.SMBusQuickFlag | smbus_go.SMBusByteFlag |
+	smbus_go.SMBusByteDataFlag | smbus_go.SMBusWordDataFlag |
+	smbus_go.SMBusProcCallFlag | smbus_go.SMBusBlockDataFlag |
+	smbus_go.SMBusBlockProcCallFlag | smbus_go.SMBusI2CBlockFlag
+
+// Funcs bitfield for a Pure-I2C adapter, like the one on a Raspberry Pi: no
+// native block transfer or block process call support, so those go through
+// emulation.go's I2C_RDWR-based emulation instead.
+const pureI2CFuncs = smbus_go.I2CFlag | smbus_go.SMBusPECFlag |
+	smbus_go.SMBusByteFlag | smbus_go.SMBusByteDataFlag |
+	smbus_go.SMBusWordDataFlag | smbus_go.SMBusProcCallFlag
+
+func newMockSMBus(t *testing.T, funcs uint32) (*smbus_go.SMBus, *mockbus.MockBus) {
+	bus := mockbus.NewMockBus(funcs)
+	smb, e := smbus_go.NewSMBusWithBackend(bus)
+	if e != nil {
+		t.Fatalf("Error creating SMBus over mockbus: %s", e)
+	}
+	return smb, bus
+}
+
+func TestDeviceReadWriteRegister(t *testing.T) {
+	smb, bus := newMockSMBus(t, fullFuncs)
+	bus.AddDevice(0x42)
+	d := smbus_go.NewDevice(smb, 0x42)
+	if e := d.WriteRegister(0x10, 0xab); e != nil {
+		t.Fatalf("Error writing register: %s", e)
+	}
+	value, e := d.ReadRegister(0x10)
+	if e != nil {
+		t.Fatalf("Error reading register: %s", e)
+	}
+	if value != 0xab {
+		t.Fatalf("Expected 0xab, got 0x%02x", value)
+	}
+}
+
+func TestDeviceUnregisteredAddress(t *testing.T) {
+	smb, _ := newMockSMBus(t, fullFuncs)
+	d := smbus_go.NewDevice(smb, 0x55)
+	if _, e := d.ReadRegister(0x00); e == nil {
+		t.Fatal("Expected an error reading from an unregistered device")
+	}
+}
+
+func TestDoHoldsOptionsForDuration(t *testing.T) {
+	smb, bus := newMockSMBus(t, fullFuncs)
+	bus.AddDevice(0x10)
+	opts := smbus_go.TransactionOptions{PEC: true, Retries: 3, Timeout: 5}
+	var sawPEC bool
+	e := smb.Do(opts, func(l *smbus_go.LockedSMBus) error {
+		sawPEC = smb.PECEnabled()
+		return l.WriteByteData(0x10, 0x01, 0x02)
+	})
+	if e != nil {
+		t.Fatalf("Error running Do: %s", e)
+	}
+	if !sawPEC {
+		t.Fatal("Expected PEC to be enabled inside the Do callback")
+	}
+	if smb.PECEnabled() {
+		t.Fatal("Expected PEC to be restored to disabled after Do returns")
+	}
+}
+
+func TestDoRestoresOptionsOnCallbackError(t *testing.T) {
+	smb, bus := newMockSMBus(t, fullFuncs)
+	bus.AddDevice(0x10)
+	opts := smbus_go.TransactionOptions{TenBit: true}
+	wantErr := "callback failed"
+	e := smb.Do(opts, func(l *smbus_go.LockedSMBus) error {
+		return fmt.Errorf(wantErr)
+	})
+	if e == nil || e.Error() != wantErr {
+		t.Fatalf("Expected error %q from Do, got %v", wantErr, e)
+	}
+	if smb.TenBitEnabled() {
+		t.Fatal("Expected 10-bit addressing to be restored after a failed " +
+			"callback")
+	}
+}
+
+func TestDoRestoresRetriesAndTimeout(t *testing.T) {
+	smb, bus := newMockSMBus(t, fullFuncs)
+	bus.AddDevice(0x10)
+	opts := smbus_go.TransactionOptions{Retries: 3, Timeout: 5}
+	var sawRetries, sawTimeout int
+	e := smb.Do(opts, func(l *smbus_go.LockedSMBus) error {
+		sawRetries = smb.Retries()
+		sawTimeout = smb.Timeout()
+		return l.WriteByteData(0x10, 0x01, 0x02)
+	})
+	if e != nil {
+		t.Fatalf("Error running Do: %s", e)
+	}
+	if sawRetries != 3 || sawTimeout != 5 {
+		t.Fatalf("Expected Retries=3, Timeout=5 inside the Do callback, got "+
+			"Retries=%d, Timeout=%d", sawRetries, sawTimeout)
+	}
+	if smb.Retries() != 0 || smb.Timeout() != 0 {
+		t.Fatalf("Expected Retries and Timeout to be restored to 0 after Do "+
+			"returns, got Retries=%d, Timeout=%d", smb.Retries(), smb.Timeout())
+	}
+}
+
+func TestAddMessageDirectionMismatch(t *testing.T) {
+	smb, _ := newMockSMBus(t, fullFuncs)
+	txn := smb.NewTransaction()
+	readMsg := smbus_go.I2CMessage{
+		Address: 0x10,
+		Flags:   smbus_go.I2CMRD,
+		Length:  1,
+		Buffer:  make([]byte, 1),
+	}
+	if e := txn.AddMessage(readMsg, false); e == nil {
+		t.Fatal("Expected an error adding a read message with isRead=false")
+	}
+}
+
+func TestAddMessageLengthExceedsBuffer(t *testing.T) {
+	smb, _ := newMockSMBus(t, fullFuncs)
+	txn := smb.NewTransaction()
+	msg := smbus_go.I2CMessage{
+		Address: 0x10,
+		Length:  4,
+		Buffer:  make([]byte, 2),
+	}
+	if e := txn.AddMessage(msg, false); e == nil {
+		t.Fatal("Expected an error adding a message whose length exceeds " +
+			"its buffer's capacity")
+	}
+}
+
+func TestAddMessageAccepted(t *testing.T) {
+	smb, bus := newMockSMBus(t, fullFuncs)
+	bus.AddDevice(0x10)
+	txn := smb.NewTransaction()
+	msg := smbus_go.I2CMessage{
+		Address: 0x10,
+		Length:  1,
+		Buffer:  []byte{0xff},
+	}
+	if e := txn.AddMessage(msg, false); e != nil {
+		t.Fatalf("Unexpected error adding a well-formed message: %s", e)
+	}
+	if e := txn.Execute(); e != nil {
+		t.Fatalf("Error executing transaction: %s", e)
+	}
+}
+
+func TestExecuteRejectsNoStartAcrossSplit(t *testing.T) {
+	smb, bus := newMockSMBus(t, fullFuncs)
+	bus.AddDevice(0x10)
+	txn := smb.NewTransaction()
+	for i := 0; i < smbus_go.I2CRdwrIoctlMaxMsgs; i++ {
+		txn.Write(0x10, []byte{0xff})
+	}
+	msg := smbus_go.I2CMessage{
+		Address: 0x10,
+		Flags:   smbus_go.I2CMNoStart,
+		Length:  1,
+		Buffer:  []byte{0xaa},
+	}
+	if e := txn.AddMessage(msg, false); e != nil {
+		t.Fatalf("Unexpected error adding a well-formed message: %s", e)
+	}
+	if e := txn.Execute(); e == nil {
+		t.Fatal("Expected an error splitting a transaction at an " +
+			"I2CMNoStart message")
+	}
+}
+
+func TestEmulatedBlockProcessCallPEC(t *testing.T) {
+	smb, bus := newMockSMBus(t, pureI2CFuncs)
+	bus.AddDevice(0x10)
+	if e := smb.EnablePEC(true); e != nil {
+		t.Fatalf("Error enabling PEC: %s", e)
+	}
+	values := []byte{0x01, 0x02, 0x03}
+	response, e := smb.BlockProcessCall(0x10, 0x20, values)
+	if e != nil {
+		t.Fatalf("Error running emulated block process call: %s", e)
+	}
+	if len(response) != len(values) {
+		t.Fatalf("Expected a %d-byte response, got %d bytes", len(values),
+			len(response))
+	}
+	for i, v := range values {
+		if response[i] != v {
+			t.Fatalf("Expected response[%d] = 0x%02x, got 0x%02x", i, v,
+				response[i])
+		}
+	}
+}
+
+func TestEmulatedReadWriteBlockData(t *testing.T) {
+	smb, bus := newMockSMBus(t, pureI2CFuncs)
+	bus.AddDevice(0x11)
+	values := []byte{0xde, 0xad, 0xbe, 0xef}
+	if e := smb.WriteBlockData(0x11, 0x30, values); e != nil {
+		t.Fatalf("Error running emulated block-data write: %s", e)
+	}
+	readBack, e := smb.ReadBlockData(0x11, 0x30)
+	if e != nil {
+		t.Fatalf("Error running emulated block-data read: %s", e)
+	}
+	if len(readBack) != len(values) {
+		t.Fatalf("Expected %d bytes back, got %d", len(values), len(readBack))
+	}
+	for i, v := range values {
+		if readBack[i] != v {
+			t.Fatalf("Expected byte %d to be 0x%02x, got 0x%02x", i, v,
+				readBack[i])
+		}
+	}
+}
+
+func TestScanFindsRegisteredDevices(t *testing.T) {
+	smb, bus := newMockSMBus(t, fullFuncs)
+	bus.AddDevice(0x20)
+	bus.AddDevice(0x40)
+	found, e := smb.Scan(0x03, 0x77)
+	if e != nil {
+		t.Fatalf("Error scanning bus: %s", e)
+	}
+	seen := make(map[uint16]bool)
+	for _, addr := range found {
+		seen[addr] = true
+	}
+	if !seen[0x20] || !seen[0x40] {
+		t.Fatalf("Expected to find 0x20 and 0x40, got %v", found)
+	}
+}
+
+func TestMockBusInjectError(t *testing.T) {
+	smb, bus := newMockSMBus(t, fullFuncs)
+	bus.AddDevice(0x10)
+	injected := fmt.Errorf("injected failure")
+	bus.InjectError(smbus_go.I2CSMBus, injected)
+	if _, e := smb.ReadByteData(0x10, 0x00); e == nil {
+		t.Fatal("Expected an error from an injected I2CSMBus failure")
+	}
+	bus.ClearError(smbus_go.I2CSMBus)
+	if _, e := smb.ReadByteData(0x10, 0x00); e != nil {
+		t.Fatalf("Unexpected error after clearing the injected failure: %s", e)
+	}
+}
@@ -0,0 +1,116 @@
+package smbus_go
+
+import "fmt"
+
+// A Transaction builds up a sequence of combined I2C_RDWR messages without
+// requiring the caller to hand-manage I2CMessage.Length versus
+// I2CMessage.Buffer capacity the way a raw I2CRdWr call does. It owns the
+// buffers it allocates, so messages built through Write and Read are always
+// safe to pass to the underlying ioctl.
+type Transaction struct {
+	bus      *SMBus
+	messages []I2CMessage
+}
+
+// Holds the destination buffer for a Read message added to a Transaction.
+// Bytes is only valid after Transaction.Execute returns a nil error.
+type TransactionRead struct {
+	buffer []byte
+}
+
+// Returns the bytes read into this message by Transaction.Execute.
+func (r *TransactionRead) Bytes() []byte {
+	return r.buffer
+}
+
+// Returns a new, empty Transaction tied to b.
+func (b *SMBus) NewTransaction() *Transaction {
+	return &Transaction{bus: b}
+}
+
+// Appends a write message for the given address, copying data into a
+// buffer owned by the Transaction.
+func (t *Transaction) Write(address uintptr, data []byte) {
+	buffer := make([]byte, len(data))
+	copy(buffer, data)
+	t.messages = append(t.messages, I2CMessage{
+		Address: uint16(address),
+		Length:  uint16(len(buffer)),
+		Buffer:  buffer,
+	})
+}
+
+// Appends a read message for the given address and returns a TransactionRead
+// that will hold the result once Execute succeeds.
+func (t *Transaction) Read(address uintptr, length int) *TransactionRead {
+	buffer := make([]byte, length)
+	t.messages = append(t.messages, I2CMessage{
+		Address: uint16(address),
+		Flags:   I2CMRD,
+		Length:  uint16(length),
+		Buffer:  buffer,
+	})
+	return &TransactionRead{buffer: buffer}
+}
+
+// Appends a caller-constructed message, e.g. one using I2CMNoStart or
+// I2CMTenBit. isRead indicates whether msg.Buffer is meant to be read into
+// (true) or written from (false); AddMessage returns an error if this
+// doesn't match msg.Flags&I2CMRD, since a mismatch here almost always means
+// the caller built the message for the wrong direction. Also returns an
+// error if msg.Length exceeds the capacity of msg.Buffer.
+func (t *Transaction) AddMessage(msg I2CMessage, isRead bool) error {
+	hasReadFlag := (msg.Flags & I2CMRD) != 0
+	if hasReadFlag != isRead {
+		return fmt.Errorf("Message's I2CMRD flag (%v) doesn't match isRead "+
+			"(%v)", hasReadFlag, isRead)
+	}
+	if int(msg.Length) > cap(msg.Buffer) {
+		return fmt.Errorf("Message length %d exceeds buffer capacity %d",
+			msg.Length, cap(msg.Buffer))
+	}
+	if len(msg.Buffer) == 0 {
+		return fmt.Errorf("Message must have at least 1 byte allocated " +
+			"in its buffer")
+	}
+	t.messages = append(t.messages, msg)
+	return nil
+}
+
+// Issues all of this Transaction's messages as one or more combined
+// I2C_RDWR transfers, splitting at I2CRdwrIoctlMaxMsgs if necessary. Read
+// results become available via each Read call's returned TransactionRead
+// once Execute returns a nil error.
+//
+// Each split point is a separate I2C_RDWR ioctl, which means the kernel
+// inserts a STOP (and a subsequent START) between chunks. If a message right
+// after a split point carries I2CMNoStart, that STOP would break the
+// continuous transaction the caller built, so Execute rejects the split
+// instead of silently executing it in pieces; add fewer messages, or avoid
+// I2CMNoStart across an I2CRdwrIoctlMaxMsgs boundary, to work around this.
+func (t *Transaction) Execute() error {
+	if len(t.messages) == 0 {
+		return fmt.Errorf("Transaction has no messages")
+	}
+	for end := I2CRdwrIoctlMaxMsgs; end < len(t.messages); end +=
+		I2CRdwrIoctlMaxMsgs {
+		if (t.messages[end].Flags & I2CMNoStart) != 0 {
+			return fmt.Errorf("Can't split transaction at message %d: it "+
+				"carries I2CMNoStart, which requires continuing the "+
+				"in-progress transaction across the split's implicit STOP",
+				end)
+		}
+	}
+	for start := 0; start < len(t.messages); start += I2CRdwrIoctlMaxMsgs {
+		end := start + I2CRdwrIoctlMaxMsgs
+		if end > len(t.messages) {
+			end = len(t.messages)
+		}
+		e := t.bus.I2CRdWr(t.messages[start:end])
+		if e != nil {
+			return fmt.Errorf("Error executing transaction messages %d-%d: "+
+				"%w", start, end-1, e)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package smbus_go
+
+import (
+	"fmt"
+)
+
+// TransactionOptions allows a caller to override PEC, 10-bit addressing, the
+// retry count, and the timeout for the duration of a single transaction.
+// These are all whole-bus settings as far as the kernel is concerned (there's
+// no i2c-dev ioctl for "PEC on this transfer only"), so Do applies them,
+// invokes fn, then restores the bus's previous settings before returning.
+type TransactionOptions struct {
+	// Use 10-bit slave addressing for this transaction.
+	TenBit bool
+	// Enable PEC (packet error checking) for this transaction.
+	PEC bool
+	// Number of times to retry the transaction if the slave doesn't
+	// acknowledge. Zero leaves the adapter's current retry count unchanged.
+	Retries int
+	// Timeout for the transaction, in units of 10ms. Zero leaves the
+	// adapter's current timeout unchanged.
+	Timeout int
+}
+
+// LockedSMBus exposes bus operations that assume b.mu is already held. It's
+// only ever handed to the fn passed to Do, so that fn can safely issue
+// transactions without re-locking (and deadlocking on) the bus's
+// non-reentrant mutex.
+type LockedSMBus struct {
+	bus *SMBus
+}
+
+// See SMBus.WriteQuick.
+func (l *LockedSMBus) WriteQuick(address uintptr) error {
+	return l.bus.writeQuick(address)
+}
+
+// See SMBus.ReadByte.
+func (l *LockedSMBus) ReadByte(address uintptr) (uint8, error) {
+	return l.bus.readByte(address)
+}
+
+// See SMBus.WriteByte.
+func (l *LockedSMBus) WriteByte(address uintptr, value uint8) error {
+	return l.bus.writeByte(address, value)
+}
+
+// See SMBus.ReadByteData.
+func (l *LockedSMBus) ReadByteData(address uintptr, register uint8) (uint8,
+	error) {
+	return l.bus.readByteData(address, register)
+}
+
+// See SMBus.WriteByteData.
+func (l *LockedSMBus) WriteByteData(address uintptr, register,
+	value uint8) error {
+	return l.bus.writeByteData(address, register, value)
+}
+
+// See SMBus.ReadWordData.
+func (l *LockedSMBus) ReadWordData(address uintptr, register uint8) (uint16,
+	error) {
+	return l.bus.readWordData(address, register)
+}
+
+// See SMBus.WriteWordData.
+func (l *LockedSMBus) WriteWordData(address uintptr, register uint8,
+	value uint16) error {
+	return l.bus.writeWordData(address, register, value)
+}
+
+// See SMBus.ProcessCall.
+func (l *LockedSMBus) ProcessCall(address uintptr, register uint8,
+	value uint16) (uint16, error) {
+	return l.bus.processCall(address, register, value)
+}
+
+// See SMBus.ReadBlockData.
+func (l *LockedSMBus) ReadBlockData(address uintptr, register uint8) ([]byte,
+	error) {
+	return l.bus.readBlockData(address, register)
+}
+
+// See SMBus.WriteBlockData.
+func (l *LockedSMBus) WriteBlockData(address uintptr, register uint8,
+	values []byte) error {
+	return l.bus.writeBlockData(address, register, values)
+}
+
+// See SMBus.BlockProcessCall.
+func (l *LockedSMBus) BlockProcessCall(address uintptr, register uint8,
+	values []byte) ([]byte, error) {
+	return l.bus.blockProcessCall(address, register, values)
+}
+
+// See SMBus.ReadI2CBlockData.
+func (l *LockedSMBus) ReadI2CBlockData(address uintptr, register,
+	length uint8) ([]byte, error) {
+	return l.bus.readI2CBlockData(address, register, length)
+}
+
+// See SMBus.WriteI2CBlockData.
+func (l *LockedSMBus) WriteI2CBlockData(address uintptr, register uint8,
+	values []byte) error {
+	return l.bus.writeI2CBlockData(address, register, values)
+}
+
+// See SMBus.I2CRdWr.
+func (l *LockedSMBus) I2CRdWr(messages []I2CMessage) error {
+	return l.bus.i2cRdWr(messages)
+}
+
+// Applies opts to the bus, calls fn with a LockedSMBus bound to this bus,
+// then restores the bus's previous PEC, 10-bit addressing, retry count, and
+// timeout. b.mu is held for the entire apply/fn/restore sequence, so fn must
+// only touch the bus through the LockedSMBus it's given, e.g.:
+//
+//	var value uint8
+//	err := bus.Do(opts, func(l *LockedSMBus) (e error) {
+//		value, e = l.ReadByteData(addr, register)
+//		return e
+//	})
+func (b *SMBus) Do(opts TransactionOptions, fn func(*LockedSMBus) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	prevPEC := b.pecEnabled
+	prevTenBit := b.tenBit
+	prevRetries := b.retries
+	prevTimeout := b.timeout
+	if opts.Retries > 0 {
+		e := b.backend.IoctlInt(I2CRetries, uintptr(opts.Retries))
+		if e != nil {
+			return fmt.Errorf("Error issuing I2C_RETRIES ioctl: %w", e)
+		}
+		b.retries = opts.Retries
+	}
+	if opts.Timeout > 0 {
+		e := b.backend.IoctlInt(I2CTimeout, uintptr(opts.Timeout))
+		if e != nil {
+			return fmt.Errorf("Error issuing I2C_TIMEOUT ioctl: %w", e)
+		}
+		b.timeout = opts.Timeout
+	}
+	if opts.TenBit != prevTenBit {
+		if e := b.setTenBit(opts.TenBit); e != nil {
+			return e
+		}
+	}
+	if opts.PEC != prevPEC {
+		if e := b.enablePEC(opts.PEC); e != nil {
+			return e
+		}
+	}
+	fnErr := fn(&LockedSMBus{bus: b})
+	var restoreErr error
+	if opts.TenBit != prevTenBit {
+		restoreErr = b.setTenBit(prevTenBit)
+	}
+	if opts.PEC != prevPEC {
+		if e := b.enablePEC(prevPEC); e != nil && restoreErr == nil {
+			restoreErr = e
+		}
+	}
+	if opts.Retries > 0 && opts.Retries != prevRetries {
+		e := b.backend.IoctlInt(I2CRetries, uintptr(prevRetries))
+		if e != nil && restoreErr == nil {
+			restoreErr = fmt.Errorf("Error restoring I2C_RETRIES: %w", e)
+		} else if e == nil {
+			b.retries = prevRetries
+		}
+	}
+	if opts.Timeout > 0 && opts.Timeout != prevTimeout {
+		e := b.backend.IoctlInt(I2CTimeout, uintptr(prevTimeout))
+		if e != nil && restoreErr == nil {
+			restoreErr = fmt.Errorf("Error restoring I2C_TIMEOUT: %w", e)
+		} else if e == nil {
+			b.timeout = prevTimeout
+		}
+	}
+	if fnErr != nil {
+		return fnErr
+	}
+	return restoreErr
+}